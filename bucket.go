@@ -0,0 +1,153 @@
+package kbucket
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// peerInfo holds all the information we track about a peer that has a slot
+// in one of the routing table's buckets.
+type peerInfo struct {
+	Id peer.ID
+
+	// lastSuccessfulOutboundQuery is the last time we successfully completed
+	// an outbound query to this peer.
+	lastSuccessfulOutboundQuery time.Time
+
+	// dhtId is the Kademlia ID of this peer, kept alongside the peer.ID so
+	// we don't have to recompute it on every comparison.
+	dhtId ID
+}
+
+// bucket is a list of peers with the most recently added peer at the front
+// and the least recently added at the back.
+type bucket struct {
+	lk   sync.RWMutex
+	list *list.List
+
+	// lastRefreshed is the last time this bucket was touched by a
+	// successful TryAddPeer or UpdateLastSuccessfulOutboundQuery, used by
+	// RefreshManager to find buckets that have gone stale.
+	lastRefreshed time.Time
+}
+
+func newBucket() *bucket {
+	b := new(bucket)
+	b.list = list.New()
+	return b
+}
+
+// touchLastRefreshed marks the bucket as refreshed as of now.
+func (b *bucket) touchLastRefreshed() {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.lastRefreshed = time.Now()
+}
+
+// getLastRefreshed returns the last time the bucket was touched.
+func (b *bucket) getLastRefreshed() time.Time {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+	return b.lastRefreshed
+}
+
+func (b *bucket) peers() []peerInfo {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+
+	ps := make([]peerInfo, 0, b.list.Len())
+	for e := b.list.Front(); e != nil; e = e.Next() {
+		ps = append(ps, *e.Value.(*peerInfo))
+	}
+	return ps
+}
+
+// getPeer returns the peerInfo for p, or nil if p is not in the bucket.
+func (b *bucket) getPeer(p peer.ID) *peerInfo {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+
+	for e := b.list.Front(); e != nil; e = e.Next() {
+		if e.Value.(*peerInfo).Id == p {
+			return e.Value.(*peerInfo)
+		}
+	}
+	return nil
+}
+
+// remove removes the peer from the bucket. Returns true if the peer was
+// present and removed.
+func (b *bucket) remove(p peer.ID) bool {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	for e := b.list.Front(); e != nil; e = e.Next() {
+		if e.Value.(*peerInfo).Id == p {
+			b.list.Remove(e)
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bucket) moveToFront(p peer.ID) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	for e := b.list.Front(); e != nil; e = e.Next() {
+		if e.Value.(*peerInfo).Id == p {
+			b.list.MoveToFront(e)
+		}
+	}
+}
+
+func (b *bucket) pushFront(p *peerInfo) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.list.PushFront(p)
+}
+
+// pushBack appends p to the back of the bucket, preserving whatever
+// least-recently-used ordering the caller is replaying, e.g. when
+// LoadRoutingTable rebuilds a bucket from a snapshot.
+func (b *bucket) pushBack(p *peerInfo) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.list.PushBack(p)
+}
+
+func (b *bucket) len() int {
+	b.lk.RLock()
+	defer b.lk.RUnlock()
+	return b.list.Len()
+}
+
+// split splits a bucket at the given common prefix length (relative to
+// target). Elements whose Kademlia ID has a common prefix length with
+// target greater than cpl are moved into the returned bucket; the rest stay
+// in b.
+func (b *bucket) split(cpl int, target ID) *bucket {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	out := list.New()
+	newbuck := newBucket()
+	newbuck.list = out
+	e := b.list.Front()
+	for e != nil {
+		pi := e.Value.(*peerInfo)
+		peerCPL := CommonPrefixLen(pi.dhtId, target)
+		if peerCPL > cpl {
+			cur := e
+			e = e.Next()
+			b.list.Remove(cur)
+			out.PushBack(cur.Value)
+			continue
+		}
+		e = e.Next()
+	}
+	return newbuck
+}
@@ -0,0 +1,184 @@
+package kbucket
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// defaultMaxPeersPerCpl is the default cap on the number of peers sharing a
+// diversity group within a single bucket.
+const defaultMaxPeersPerCpl = 2
+
+// defaultMaxPeersPerGroup is the default cap on the number of peers sharing
+// a diversity group across the whole routing table.
+const defaultMaxPeersPerGroup = 3
+
+// PeerDiversityFilter lets a RoutingTable cap how many peers that share a
+// network attribute (for example an IP prefix or ASN) may occupy a single
+// bucket, or the table as a whole. It is consulted by TryAddPeer before a
+// candidate peer is admitted, which protects the table against eclipse
+// attacks mounted from peers clustered on the same network.
+type PeerDiversityFilter interface {
+	// Allow reports whether candidate may be added to the bucket for the
+	// given common prefix length. Implementations that allow the peer are
+	// expected to record it against their internal counts, so a matching
+	// Remove call is required once the peer leaves that bucket.
+	Allow(candidate peer.ID, cpl int) bool
+
+	// Remove tells the filter that a peer it previously allowed into the
+	// bucket for the given cpl has left the table, so its counts stay
+	// consistent with evictions performed outside of Allow.
+	Remove(p peer.ID, cpl int)
+
+	// Move tells the filter that a peer it previously allowed into the
+	// bucket for fromCpl has been relocated to toCpl without a new
+	// admission decision, e.g. when a bucket split moves it. Unlike
+	// Allow, Move never rejects: the peer already holds a table slot, so
+	// only the filter's group accounting changes.
+	Move(p peer.ID, fromCpl, toCpl int)
+}
+
+// AddrSource is the subset of peerstore.Peerstore that IPGroupFilter needs
+// to classify a peer by its known addresses. Any peerstore.Peerstore
+// satisfies it directly.
+type AddrSource interface {
+	// Addrs returns the known multiaddrs for p.
+	Addrs(p peer.ID) []ma.Multiaddr
+}
+
+// IPGroupFilter is the default PeerDiversityFilter. It groups peers by the
+// /16 prefix of their IPv4 host address, or the /32 prefix of their IPv6
+// host address, resolving addresses via an AddrSource.
+type IPGroupFilter struct {
+	addrs AddrSource
+
+	maxPerCpl   int
+	maxPerGroup int
+
+	mu sync.Mutex
+
+	cplGroupCount   map[int]map[string]int
+	tableGroupCount map[string]int
+	peerGroup       map[peer.ID]string
+}
+
+// NewIPGroupFilter creates an IPGroupFilter that resolves peer addresses
+// from addrs, capping each group to maxPerCpl peers per bucket and
+// maxPerGroup peers across the table.
+func NewIPGroupFilter(addrs AddrSource, maxPerCpl, maxPerGroup int) *IPGroupFilter {
+	if maxPerCpl <= 0 {
+		maxPerCpl = defaultMaxPeersPerCpl
+	}
+	if maxPerGroup <= 0 {
+		maxPerGroup = defaultMaxPeersPerGroup
+	}
+
+	return &IPGroupFilter{
+		addrs:           addrs,
+		maxPerCpl:       maxPerCpl,
+		maxPerGroup:     maxPerGroup,
+		cplGroupCount:   make(map[int]map[string]int),
+		tableGroupCount: make(map[string]int),
+		peerGroup:       make(map[peer.ID]string),
+	}
+}
+
+// groupKey derives a diversity group key for p from the host addresses the
+// AddrSource knows about. It returns false if none of p's multiaddrs could
+// be resolved to an IP.
+func (f *IPGroupFilter) groupKey(p peer.ID) (string, bool) {
+	for _, addr := range f.addrs.Addrs(p) {
+		ip, err := manet.ToIP(addr)
+		if err != nil {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return fmt.Sprintf("4:%d.%d", ip4[0], ip4[1]), true
+		}
+		if ip16 := ip.To16(); ip16 != nil {
+			return fmt.Sprintf("6:%x", []byte(ip16[:4])), true
+		}
+	}
+	return "", false
+}
+
+// Allow implements PeerDiversityFilter.
+func (f *IPGroupFilter) Allow(candidate peer.ID, cpl int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key, ok := f.groupKey(candidate)
+	if !ok {
+		// we have no address to classify the peer by; don't reject it on
+		// diversity grounds.
+		return true
+	}
+
+	if f.tableGroupCount[key] >= f.maxPerGroup {
+		return false
+	}
+	if f.cplGroupCount[cpl][key] >= f.maxPerCpl {
+		return false
+	}
+
+	f.peerGroup[candidate] = key
+	f.tableGroupCount[key]++
+	if f.cplGroupCount[cpl] == nil {
+		f.cplGroupCount[cpl] = make(map[string]int)
+	}
+	f.cplGroupCount[cpl][key]++
+	return true
+}
+
+// Remove implements PeerDiversityFilter.
+func (f *IPGroupFilter) Remove(p peer.ID, cpl int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key, ok := f.peerGroup[p]
+	if !ok {
+		return
+	}
+	delete(f.peerGroup, p)
+
+	f.tableGroupCount[key]--
+	if f.tableGroupCount[key] <= 0 {
+		delete(f.tableGroupCount, key)
+	}
+	if m := f.cplGroupCount[cpl]; m != nil {
+		m[key]--
+		if m[key] <= 0 {
+			delete(m, key)
+		}
+	}
+}
+
+// Move implements PeerDiversityFilter.
+func (f *IPGroupFilter) Move(p peer.ID, fromCpl, toCpl int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if fromCpl == toCpl {
+		return
+	}
+
+	key, ok := f.peerGroup[p]
+	if !ok {
+		return
+	}
+
+	if m := f.cplGroupCount[fromCpl]; m != nil {
+		m[key]--
+		if m[key] <= 0 {
+			delete(m, key)
+		}
+	}
+	if f.cplGroupCount[toCpl] == nil {
+		f.cplGroupCount[toCpl] = make(map[string]int)
+	}
+	f.cplGroupCount[toCpl][key]++
+}
@@ -0,0 +1,223 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAddrSource is a minimal AddrSource backed by an in-memory map, used
+// in place of a full peerstore.Peerstore so these tests don't need to
+// construct one.
+type fakeAddrSource struct {
+	addrs map[peer.ID][]ma.Multiaddr
+}
+
+func newFakeAddrSource() *fakeAddrSource {
+	return &fakeAddrSource{addrs: make(map[peer.ID][]ma.Multiaddr)}
+}
+
+func (f *fakeAddrSource) Addrs(p peer.ID) []ma.Multiaddr {
+	return f.addrs[p]
+}
+
+// addPeerWithIP registers a random peer.ID with f, giving it a single
+// multiaddr on the given IPv4 address, and returns the peer.ID.
+func addPeerWithIP(t *testing.T, f *fakeAddrSource, ip string) peer.ID {
+	t.Helper()
+
+	p := test.RandPeerIDFatal(t)
+	addr, err := ma.NewMultiaddr("/ip4/" + ip + "/tcp/4001")
+	require.NoError(t, err)
+	f.addrs[p] = []ma.Multiaddr{addr}
+	return p
+}
+
+func TestIPGroupFilterSaturatesBucket(t *testing.T) {
+	t.Parallel()
+
+	addrs := newFakeAddrSource()
+	df := NewIPGroupFilter(addrs, 2, 3)
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTableWithOptions(10, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, WithPeerDiversityFilter(df))
+	require.NoError(t, err)
+
+	// two peers from the same /16 fit within the per-cpl cap.
+	p1 := addPeerWithIP(t, addrs, "1.2.3.4")
+	p2 := addPeerWithIP(t, addrs, "1.2.9.9")
+	ok, err := rt.TryAddPeer(p1, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = rt.TryAddPeer(p2, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// a third peer from the same /16 exceeds it and is refused.
+	p3 := addPeerWithIP(t, addrs, "1.2.7.7")
+	ok, err = rt.TryAddPeer(p3, true)
+	require.Equal(t, ErrPeerRejectedLowDiversity, err)
+	require.False(t, ok)
+	require.Equal(t, 2, rt.Size())
+
+	// a peer from a different /16 is unaffected by the first group's cap.
+	p4 := addPeerWithIP(t, addrs, "8.8.8.8")
+	ok, err = rt.TryAddPeer(p4, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 3, rt.Size())
+
+	// removing a peer frees up its group's count, letting a new member in.
+	rt.RemovePeer(p1)
+	p5 := addPeerWithIP(t, addrs, "1.2.5.5")
+	ok, err = rt.TryAddPeer(p5, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestIPGroupFilterTableWideCap(t *testing.T) {
+	t.Parallel()
+
+	addrs := newFakeAddrSource()
+	// a per-cpl cap high enough not to interfere, so only the table-wide
+	// cap is exercised.
+	df := NewIPGroupFilter(addrs, 10, 2)
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTableWithOptions(10, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, WithPeerDiversityFilter(df))
+	require.NoError(t, err)
+
+	p1 := addPeerWithIP(t, addrs, "1.2.3.4")
+	p2 := addPeerWithIP(t, addrs, "1.2.9.9")
+	p3 := addPeerWithIP(t, addrs, "1.2.7.7")
+
+	ok, err := rt.TryAddPeer(p1, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = rt.TryAddPeer(p2, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// the table-wide cap of 2 is already met; a third same-group peer is refused.
+	ok, err = rt.TryAddPeer(p3, true)
+	require.Equal(t, ErrPeerRejectedLowDiversity, err)
+	require.False(t, ok)
+}
+
+// TestIPGroupFilterNoPhantomCountOnCapacityRejection verifies that a peer
+// which passes the diversity check but then loses to a capacity rejection
+// doesn't leave a stale count behind in the filter: a same-group peer
+// added afterwards, once there is room, must still be allowed in.
+func TestIPGroupFilterNoPhantomCountOnCapacityRejection(t *testing.T) {
+	t.Parallel()
+
+	addrs := newFakeAddrSource()
+	// caps of 1 so that a phantom count left by p1 would be enough to
+	// refuse p2.
+	df := NewIPGroupFilter(addrs, 1, 1)
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTableWithOptions(1, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, WithPeerDiversityFilter(df))
+	require.NoError(t, err)
+
+	// fill bucket 0 (capacity 1) with an unrelated peer, then split the
+	// table so bucket 0 is no longer eligible to grow by splitting.
+	filler := test.RandPeerIDFatal(t)
+	for CommonPrefixLen(ConvertPeerID(filler), ConvertPeerID(local)) != 0 {
+		filler = test.RandPeerIDFatal(t)
+	}
+	ok, err := rt.TryAddPeer(filler, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+	rt.nextBucket()
+
+	// p1 diversity-passes but is then rejected for lack of capacity, since
+	// bucket 0 is full and no longer the last bucket.
+	p1 := addPeerWithIP(t, addrs, "1.2.3.4")
+	for CommonPrefixLen(ConvertPeerID(p1), ConvertPeerID(local)) != 0 {
+		p1 = addPeerWithIP(t, addrs, "1.2.3.4")
+	}
+	ok, err = rt.TryAddPeer(p1, true)
+	require.Equal(t, ErrPeerRejectedNoCapacity, err)
+	require.False(t, ok)
+
+	// removing the filler frees bucket 0 up again; a fresh same-group peer
+	// must be allowed in, proving p1's rejected attempt left no phantom
+	// count behind in the filter.
+	rt.RemovePeer(filler)
+	p2 := addPeerWithIP(t, addrs, "1.2.9.9")
+	for CommonPrefixLen(ConvertPeerID(p2), ConvertPeerID(local)) != 0 {
+		p2 = addPeerWithIP(t, addrs, "1.2.9.9")
+	}
+	ok, err = rt.TryAddPeer(p2, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// TestIPGroupFilterCapHoldsAcrossSplit verifies that a bucket split doesn't
+// let the per-bucket cap go stale: a peer admitted while its bucket is
+// still the (only) last bucket, then relocated into a new bucket by a
+// later split, must still count against that new bucket's cap.
+func TestIPGroupFilterCapHoldsAcrossSplit(t *testing.T) {
+	t.Parallel()
+
+	addrs := newFakeAddrSource()
+	df := NewIPGroupFilter(addrs, 1, 10)
+
+	local := test.RandPeerIDFatal(t)
+	localID := ConvertPeerID(local)
+	// bucketsize 2 so bucket 1 has a spare slot after the split below,
+	// keeping this test about the diversity cap rather than capacity.
+	rt, err := NewRoutingTableWithOptions(2, localID, time.Hour, pstore.NewMetrics(), NoOpThreshold, WithPeerDiversityFilter(df))
+	require.NoError(t, err)
+
+	// p1 is admitted while bucket 0 is the table's only bucket, so the
+	// filter records it under cpl 0 regardless of its real cpl.
+	p1 := addPeerWithIP(t, addrs, "1.2.3.4")
+	for CommonPrefixLen(ConvertPeerID(p1), localID) == 0 {
+		p1 = addPeerWithIP(t, addrs, "1.2.3.4")
+	}
+	ok, err := rt.TryAddPeer(p1, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// filler and trigger both share cpl 0 with local; together with p1
+	// they overflow bucket 0 (capacity 2) and force a split: p1 relocates
+	// to the new bucket 1, while filler and trigger stay behind in
+	// bucket 0.
+	filler := test.RandPeerIDFatal(t)
+	for CommonPrefixLen(ConvertPeerID(filler), localID) != 0 {
+		filler = test.RandPeerIDFatal(t)
+	}
+	ok, err = rt.TryAddPeer(filler, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	trigger := test.RandPeerIDFatal(t)
+	for CommonPrefixLen(ConvertPeerID(trigger), localID) != 0 {
+		trigger = test.RandPeerIDFatal(t)
+	}
+	ok, err = rt.TryAddPeer(trigger, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.Equal(t, 1, rt.bucketIdForPeer(p1), "p1 should have relocated to bucket 1 by the split")
+	require.Equal(t, 1, rt.buckets[1].len(), "bucket 1 should have a spare slot for p2")
+
+	// p2 shares p1's IP group and its real bucket (1); with maxPerCpl 1,
+	// it must be rejected, proving the filter re-keyed p1 to bucket 1
+	// instead of still attributing its slot to the now-stale cpl 0.
+	p2 := addPeerWithIP(t, addrs, "1.2.9.9")
+	for CommonPrefixLen(ConvertPeerID(p2), localID) == 0 {
+		p2 = addPeerWithIP(t, addrs, "1.2.9.9")
+	}
+	ok, err = rt.TryAddPeer(p2, true)
+	require.Equal(t, ErrPeerRejectedLowDiversity, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,2 @@
+// Package kbucket implements a kademlia k-bucket routing table.
+package kbucket
@@ -0,0 +1,34 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/stretchr/testify/require"
+)
+
+// shortKey derives a 1-byte key from the last byte of the peer.ID, for
+// testing WithKeyFunc with a keyspace shorter than the default 256 bits.
+func shortKey(p peer.ID) ID {
+	full := ConvertPeerID(p)
+	return ID{full[len(full)-1]}
+}
+
+func TestWithKeyFuncUsesShorterKeyspace(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTableWithOptions(10, shortKey(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, WithKeyFunc(shortKey))
+	require.NoError(t, err)
+
+	p := test.RandPeerIDFatal(t)
+	ok, err := rt.TryAddPeer(p, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.Contains(t, rt.ListPeers(), p)
+	require.Equal(t, 0, rt.bucketIdForPeer(p), "with a single bucket, every peer lands at index 0 regardless of its actual CPL")
+}
@@ -0,0 +1,41 @@
+package kbucket
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// MetricsReporter receives structured events describing RoutingTable
+// health, so operators can get visibility into it analogous to the ad-hoc
+// instrumentation commonly hand-rolled around DHT routing tables. A
+// RoutingTable is configured with one via WithMetricsReporter.
+type MetricsReporter interface {
+	// PeerAdded is called whenever a peer is added to the table.
+	PeerAdded(p peer.ID)
+
+	// PeerRemoved is called whenever a peer is removed from the table,
+	// whether explicitly via RemovePeer or through eviction.
+	PeerRemoved(p peer.ID)
+
+	// PeerRejected is called whenever TryAddPeer refuses a candidate,
+	// with reason set to the error TryAddPeer returned.
+	PeerRejected(p peer.ID, reason error)
+
+	// BucketSplit is called whenever a bucket split occurs, with cpl set
+	// to the CPL of the bucket that was split and sizeLeft/sizeRight set
+	// to the peer counts of the two resulting buckets.
+	BucketSplit(cpl int, sizeLeft, sizeRight int)
+
+	// LookupServed is called after NearestPeers answers a lookup for
+	// target, with returned set to the number of peers it found and
+	// latency set to how long the lookup took.
+	LookupServed(target ID, k int, returned int, latency time.Duration)
+
+	// BucketOccupancy reports the current peer count of the bucket for
+	// the given CPL. It is called after TryAddPeer adds a peer to that
+	// bucket and after RemovePeer removes one; the sizes of the two
+	// buckets left by a split are reported through BucketSplit instead,
+	// and a peer evicted to make room is not reported separately.
+	BucketOccupancy(cpl int, size int)
+}
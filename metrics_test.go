@@ -0,0 +1,146 @@
+package kbucket
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/stretchr/testify/require"
+)
+
+// testReporter is a MetricsReporter that records the events it receives,
+// for assertions in tests.
+type testReporter struct {
+	mu sync.Mutex
+
+	added     []peer.ID
+	removed   []peer.ID
+	rejected  []error
+	splits    int
+	occupancy []int
+	lookups   int
+}
+
+func (r *testReporter) PeerAdded(p peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.added = append(r.added, p)
+}
+
+func (r *testReporter) PeerRemoved(p peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removed = append(r.removed, p)
+}
+
+func (r *testReporter) PeerRejected(_ peer.ID, reason error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rejected = append(r.rejected, reason)
+}
+
+func (r *testReporter) BucketSplit(cpl int, sizeLeft, sizeRight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.splits++
+}
+
+func (r *testReporter) LookupServed(target ID, k int, returned int, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+}
+
+func (r *testReporter) BucketOccupancy(cpl int, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.occupancy = append(r.occupancy, size)
+}
+
+func TestMetricsReporterAddAndSplit(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	localID := ConvertPeerID(local)
+
+	rt, err := NewRoutingTableWithOptions(2, localID, time.Hour, pstore.NewMetrics(), NoOpThreshold, WithMetricsReporter(&testReporter{}))
+	require.NoError(t, err)
+	r := rt.reporter.(*testReporter)
+
+	// with bucketsize 2, a capacity rejection is a possible outcome once
+	// bucket 0 has split and is no longer eligible to grow by splitting
+	// further, so only count successes rather than requiring every
+	// TryAddPeer to succeed.
+	added := 0
+	for i := 0; i < 5; i++ {
+		p := test.RandPeerIDFatal(t)
+		ok, _ := rt.TryAddPeer(p, true)
+		if ok {
+			added++
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	require.Len(t, r.added, added)
+	require.NotEmpty(t, r.occupancy)
+	require.True(t, r.splits > 0, "expected at least one bucket split once the first bucket overflowed")
+}
+
+func TestMetricsReporterRejectedOnNoCapacity(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	localID := ConvertPeerID(local)
+
+	r := &testReporter{}
+	rt, err := NewRoutingTableWithOptions(1, localID, time.Hour, pstore.NewMetrics(), NoOpThreshold, WithMetricsReporter(r))
+	require.NoError(t, err)
+
+	// with bucketsize 1, seeding enough peers forces the table to split
+	// past bucket 0, so a second peer sharing bucket 0's CPL (0) finds it
+	// full and not eligible to split any further, and is rejected.
+	for i := 0; i < 30 && len(rt.buckets) < 3; i++ {
+		p := test.RandPeerIDFatal(t)
+		_, _ = rt.TryAddPeer(p, true)
+	}
+	require.True(t, len(rt.buckets) >= 3, "expected the table to have split several times")
+
+	for i := 0; i < 50; i++ {
+		p := test.RandPeerIDFatal(t)
+		if CommonPrefixLen(ConvertPeerID(p), localID) != 0 {
+			continue
+		}
+		if ok, _ := rt.TryAddPeer(p, true); !ok {
+			r.mu.Lock()
+			require.NotEmpty(t, r.rejected)
+			r.mu.Unlock()
+			return
+		}
+	}
+	t.Fatal("expected a cpl-0 peer to eventually be rejected once bucket 0 was full")
+}
+
+func TestMetricsReporterRemoved(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	localID := ConvertPeerID(local)
+
+	r := &testReporter{}
+	rt, err := NewRoutingTableWithOptions(5, localID, time.Hour, pstore.NewMetrics(), NoOpThreshold, WithMetricsReporter(r))
+	require.NoError(t, err)
+
+	p := test.RandPeerIDFatal(t)
+	_, err = rt.TryAddPeer(p, true)
+	require.NoError(t, err)
+
+	rt.RemovePeer(p)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	require.Equal(t, []peer.ID{p}, r.removed)
+}
@@ -0,0 +1,36 @@
+package kbucket
+
+// Option is used to configure optional parameters for a RoutingTable, on
+// top of the required ones taken directly by NewRoutingTable.
+type Option func(*RoutingTable) error
+
+// WithPeerDiversityFilter configures the RoutingTable to consult df before
+// admitting a peer, so it can reject peers that would make the table (or
+// one of its buckets) too dominated by a single network group.
+func WithPeerDiversityFilter(df PeerDiversityFilter) Option {
+	return func(rt *RoutingTable) error {
+		rt.df = df
+		return nil
+	}
+}
+
+// WithMetricsReporter configures the RoutingTable to emit structured events
+// to r as it adds, rejects, and removes peers, splits buckets, and serves
+// lookups.
+func WithMetricsReporter(r MetricsReporter) Option {
+	return func(rt *RoutingTable) error {
+		rt.reporter = r
+		return nil
+	}
+}
+
+// WithKeyFunc configures the RoutingTable to derive each peer's Kademlia
+// key with kf instead of the default ConvertPeerID, so callers can plug in
+// a different hash or a shorter keyspace for testing or alternative
+// keyspace choices.
+func WithKeyFunc(kf KeyFunc) Option {
+	return func(rt *RoutingTable) error {
+		rt.keyFunc = kf
+		return nil
+	}
+}
@@ -0,0 +1,167 @@
+// Package qpeerset provides QueryPeerset, a peer set used to drive an
+// iterative Kademlia lookup against a kbucket.RoutingTable.
+package qpeerset
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// PeerState describes where a peer stands in an in-progress lookup.
+type PeerState int
+
+const (
+	// PeerHeard means we know about the peer but have not yet queried it.
+	PeerHeard PeerState = iota
+	// PeerWaiting means a query to the peer is in flight.
+	PeerWaiting
+	// PeerQueried means a query to the peer has completed successfully.
+	PeerQueried
+	// PeerUnreachable means a query to the peer failed.
+	PeerUnreachable
+)
+
+// queryPeerState is the bookkeeping QueryPeerset keeps for a single peer.
+type queryPeerState struct {
+	id       peer.ID
+	distance *big.Int
+	state    PeerState
+}
+
+// QueryPeerset holds the peers known to an in-progress Kademlia lookup for
+// target, ordered by ascending XOR distance to it, along with each peer's
+// PeerState and the peer that referred it.
+type QueryPeerset struct {
+	target kbucket.ID
+
+	all      []queryPeerState
+	referrer map[peer.ID]peer.ID
+}
+
+// NewQueryPeerset creates an empty QueryPeerset for target.
+func NewQueryPeerset(target kbucket.ID) *QueryPeerset {
+	return &QueryPeerset{
+		target:   target,
+		referrer: make(map[peer.ID]peer.ID),
+	}
+}
+
+// NewQueryPeersetFromRT creates a QueryPeerset for target, seeded with the
+// k peers of rt closest to it, each marked PeerHeard with no referrer.
+func NewQueryPeersetFromRT(rt *kbucket.RoutingTable, target kbucket.ID, k int) *QueryPeerset {
+	qp := NewQueryPeerset(target)
+	for _, p := range rt.NearestPeers(target, k) {
+		qp.TryAdd(p, "")
+	}
+	return qp
+}
+
+// xorDistance returns the XOR distance between a and b as a big.Int,
+// treating any length mismatch as zero-padding on the shorter key.
+func xorDistance(a, b kbucket.ID) *big.Int {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+
+	out := make([]byte, n)
+	for i := 0; i < n; i++ {
+		var av, bv byte
+		if i < len(a) {
+			av = a[i]
+		}
+		if i < len(b) {
+			bv = b[i]
+		}
+		out[i] = av ^ bv
+	}
+	return new(big.Int).SetBytes(out)
+}
+
+// indexOf returns the index of p in qp.all, or -1 if it isn't present.
+func (qp *QueryPeerset) indexOf(p peer.ID) int {
+	for i := range qp.all {
+		if qp.all[i].id == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// TryAdd inserts p into the peerset as PeerHeard if it isn't already
+// present, recording referrer as the peer that informed us about it.
+// Returns whether p was added.
+func (qp *QueryPeerset) TryAdd(p, referrer peer.ID) bool {
+	if qp.indexOf(p) != -1 {
+		return false
+	}
+
+	qp.all = append(qp.all, queryPeerState{
+		id:       p,
+		distance: xorDistance(qp.target, kbucket.ConvertPeerID(p)),
+		state:    PeerHeard,
+	})
+	qp.referrer[p] = referrer
+
+	sort.Slice(qp.all, func(i, j int) bool {
+		return qp.all[i].distance.Cmp(qp.all[j].distance) < 0
+	})
+
+	return true
+}
+
+// SetState updates the PeerState recorded for p. It is a no-op if p is not
+// in the peerset.
+func (qp *QueryPeerset) SetState(p peer.ID, s PeerState) {
+	if i := qp.indexOf(p); i != -1 {
+		qp.all[i].state = s
+	}
+}
+
+// GetReferrer returns the peer that referred p to this peerset, or the
+// zero peer.ID if p is not present or has no referrer.
+func (qp *QueryPeerset) GetReferrer(p peer.ID) peer.ID {
+	return qp.referrer[p]
+}
+
+// NumWaiting returns the number of peers currently in the PeerWaiting
+// state.
+func (qp *QueryPeerset) NumWaiting() int {
+	n := 0
+	for _, ps := range qp.all {
+		if ps.state == PeerWaiting {
+			n++
+		}
+	}
+	return n
+}
+
+// GetClosestNInStates returns, in ascending distance order, up to n peers
+// whose state is one of states.
+func (qp *QueryPeerset) GetClosestNInStates(n int, states ...PeerState) []peer.ID {
+	want := make(map[PeerState]bool, len(states))
+	for _, s := range states {
+		want[s] = true
+	}
+
+	var out []peer.ID
+	for _, ps := range qp.all {
+		if len(out) == n {
+			break
+		}
+		if want[ps.state] {
+			out = append(out, ps.id)
+		}
+	}
+	return out
+}
+
+// GetClosestNotUnreachable returns, in ascending distance order, up to k
+// peers that are not in the PeerUnreachable state.
+func (qp *QueryPeerset) GetClosestNotUnreachable(k int) []peer.ID {
+	return qp.GetClosestNInStates(k, PeerHeard, PeerWaiting, PeerQueried)
+}
@@ -0,0 +1,99 @@
+package qpeerset
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/stretchr/testify/require"
+
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+var noOpThreshold = float64(100 * time.Hour)
+
+func TestTryAddAndSetState(t *testing.T) {
+	t.Parallel()
+
+	target := kbucket.ConvertKey("target")
+	qp := NewQueryPeerset(target)
+
+	p1 := test.RandPeerIDFatal(t)
+	ref := test.RandPeerIDFatal(t)
+
+	require.True(t, qp.TryAdd(p1, ref))
+	require.False(t, qp.TryAdd(p1, ref), "re-adding the same peer should be a no-op")
+	require.Equal(t, ref, qp.GetReferrer(p1))
+
+	qp.SetState(p1, PeerWaiting)
+	require.Equal(t, 1, qp.NumWaiting())
+
+	qp.SetState(p1, PeerQueried)
+	require.Equal(t, 0, qp.NumWaiting())
+}
+
+func TestGetClosestNInStates(t *testing.T) {
+	t.Parallel()
+
+	target := kbucket.ConvertKey("target")
+	qp := NewQueryPeerset(target)
+
+	peers := make([]string, 4)
+	for i := range peers {
+		p := test.RandPeerIDFatal(t)
+		qp.TryAdd(p, "")
+		peers[i] = string(p)
+	}
+
+	// mark the two closest peers as queried, leave the rest heard.
+	closest := qp.GetClosestNInStates(len(peers), PeerHeard, PeerWaiting, PeerQueried, PeerUnreachable)
+	require.Len(t, closest, len(peers))
+	for _, p := range closest[:2] {
+		qp.SetState(p, PeerQueried)
+	}
+
+	require.Equal(t, closest[:2], qp.GetClosestNInStates(2, PeerQueried))
+	require.Equal(t, closest[2:], qp.GetClosestNInStates(len(peers), PeerHeard))
+}
+
+// TestQueryWalkTerminates simulates a lookup against k = 3: peers are
+// marked Queried or Unreachable as the walk progresses, and once the 3
+// closest are all in one of those two terminal states there is nothing
+// left to query, which is how a real lookup knows to stop.
+func TestQueryWalkTerminates(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := kbucket.NewRoutingTable(20, kbucket.ConvertPeerID(local), time.Hour, pstore.NewMetrics(), noOpThreshold)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		p := test.RandPeerIDFatal(t)
+		ok, err := rt.TryAddPeer(p, true)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	target := kbucket.ConvertKey("lookup target")
+	const k = 3
+	qp := NewQueryPeersetFromRT(rt, target, k)
+
+	closest := qp.GetClosestNotUnreachable(k)
+	require.Len(t, closest, k)
+
+	// the walk queries each of the k closest peers in turn.
+	for i, p := range closest {
+		qp.SetState(p, PeerWaiting)
+		require.Equal(t, 1, qp.NumWaiting())
+
+		if i%2 == 0 {
+			qp.SetState(p, PeerQueried)
+		} else {
+			qp.SetState(p, PeerUnreachable)
+		}
+	}
+
+	require.Equal(t, 0, qp.NumWaiting())
+	require.Empty(t, qp.GetClosestNInStates(k, PeerHeard, PeerWaiting))
+}
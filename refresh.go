@@ -0,0 +1,132 @@
+package kbucket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// defaultRefreshInterval is the default duration a bucket may go untouched
+// before RefreshManager requests a refresh for it.
+const defaultRefreshInterval = 10 * time.Minute
+
+// RefreshRequest asks the consumer of RefreshRequests to drive a Kademlia
+// lookup for Target, which falls within Cpl of the local peer, so as to
+// refresh the corresponding bucket.
+type RefreshRequest struct {
+	Cpl    uint
+	Target peer.ID
+}
+
+// RefreshManager watches rt's buckets and emits a RefreshRequest for any
+// bucket that has gone longer than refreshInterval without being touched
+// by a successful TryAddPeer or UpdateLastSuccessfulOutboundQuery. It lets
+// external code (typically a DHT's lookup loop) drive proactive refreshes
+// of stale buckets instead of relying on ad-hoc external scheduling.
+type RefreshManager struct {
+	rt              *RoutingTable
+	refreshInterval time.Duration
+
+	reqCh   chan RefreshRequest
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewRefreshManager creates a RefreshManager for rt. A refreshInterval of
+// zero uses defaultRefreshInterval.
+func NewRefreshManager(rt *RoutingTable, refreshInterval time.Duration) *RefreshManager {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultRefreshInterval
+	}
+
+	return &RefreshManager{
+		rt:              rt,
+		refreshInterval: refreshInterval,
+		reqCh:           make(chan RefreshRequest),
+		closeCh:         make(chan struct{}),
+	}
+}
+
+// Start begins watching rt's buckets for staleness in a background
+// goroutine, checking every refreshInterval/2.
+func (rm *RefreshManager) Start() {
+	rm.wg.Add(1)
+	go rm.loop()
+}
+
+// Close stops the background goroutine started by Start and waits for it
+// to exit.
+func (rm *RefreshManager) Close() error {
+	close(rm.closeCh)
+	rm.wg.Wait()
+	return nil
+}
+
+// RefreshRequests returns the channel RefreshManager emits RefreshRequests
+// on.
+func (rm *RefreshManager) RefreshRequests() <-chan RefreshRequest {
+	return rm.reqCh
+}
+
+// GetTrackedCpls returns the CPLs currently backed by (or eligible for) a
+// bucket, i.e. 0..n-1 for a table with n buckets.
+func (rm *RefreshManager) GetTrackedCpls() []uint {
+	rm.rt.tabLock.RLock()
+	defer rm.rt.tabLock.RUnlock()
+
+	cpls := make([]uint, len(rm.rt.buckets))
+	for i := range rm.rt.buckets {
+		cpls[i] = uint(i)
+	}
+	return cpls
+}
+
+// GetTrackedCplsForRefresh returns the lastRefreshed time of each bucket,
+// indexed by CPL.
+func (rm *RefreshManager) GetTrackedCplsForRefresh() []time.Time {
+	rm.rt.tabLock.RLock()
+	defer rm.rt.tabLock.RUnlock()
+
+	times := make([]time.Time, len(rm.rt.buckets))
+	for i, b := range rm.rt.buckets {
+		times[i] = b.getLastRefreshed()
+	}
+	return times
+}
+
+func (rm *RefreshManager) loop() {
+	defer rm.wg.Done()
+
+	ticker := time.NewTicker(rm.refreshInterval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rm.checkStaleBuckets()
+		case <-rm.closeCh:
+			return
+		}
+	}
+}
+
+func (rm *RefreshManager) checkStaleBuckets() {
+	for cpl, last := range rm.GetTrackedCplsForRefresh() {
+		if !last.IsZero() && time.Since(last) < rm.refreshInterval {
+			continue
+		}
+
+		target, err := rm.rt.GenRandPeerID(uint(cpl))
+		if err != nil {
+			continue
+		}
+
+		req := RefreshRequest{Cpl: uint(cpl), Target: target}
+		select {
+		case rm.reqCh <- req:
+		case <-rm.closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshManagerTracksCpls(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(2, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold)
+	require.NoError(t, err)
+
+	rm := NewRefreshManager(rt, time.Hour)
+	require.Equal(t, []uint{0}, rm.GetTrackedCpls())
+	require.Len(t, rm.GetTrackedCplsForRefresh(), 1)
+}
+
+func TestRefreshManagerEmitsStaleBucket(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(2, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold)
+	require.NoError(t, err)
+
+	rm := NewRefreshManager(rt, 20*time.Millisecond)
+	rm.Start()
+	defer rm.Close()
+
+	select {
+	case req := <-rm.RefreshRequests():
+		require.Equal(t, uint(0), req.Cpl)
+		require.NotEmpty(t, req.Target)
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a refresh request for the untouched bucket")
+	}
+}
+
+func TestRefreshManagerSkipsRecentlyTouchedBucket(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(2, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold)
+	require.NoError(t, err)
+
+	rm := NewRefreshManager(rt, time.Hour)
+
+	p := test.RandPeerIDFatal(t)
+	ok, err := rt.TryAddPeer(p, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	times := rm.GetTrackedCplsForRefresh()
+	require.Len(t, times, 1)
+	require.False(t, times[0].IsZero())
+	require.WithinDuration(t, time.Now(), times[0], time.Minute)
+
+	// a staleness check right after the add finds the bucket fresh and
+	// emits no request for it.
+	done := make(chan struct{})
+	go func() {
+		rm.checkStaleBuckets()
+		close(done)
+	}()
+	select {
+	case req := <-rm.RefreshRequests():
+		t.Fatalf("unexpected refresh request for cpl %d", req.Cpl)
+	case <-done:
+	}
+}
@@ -0,0 +1,108 @@
+// Package rtmetrics provides a kbucket.MetricsReporter implementation
+// backed by prometheus/client_golang, kept as a separate module so that
+// depending on the core kbucket package doesn't pull in a Prometheus
+// client for users who don't want one.
+package rtmetrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+)
+
+var _ kbucket.MetricsReporter = (*PrometheusReporter)(nil)
+
+// PrometheusReporter is a kbucket.MetricsReporter that exports RoutingTable
+// events as Prometheus counters, a histogram, and a gauge.
+type PrometheusReporter struct {
+	peersAdded      prometheus.Counter
+	peersRemoved    prometheus.Counter
+	peersRejected   *prometheus.CounterVec
+	bucketSplits    prometheus.Counter
+	lookupsServed   prometheus.Histogram
+	bucketOccupancy *prometheus.GaugeVec
+}
+
+// NewPrometheusReporter creates a PrometheusReporter and registers its
+// collectors with reg.
+func NewPrometheusReporter(reg prometheus.Registerer) (*PrometheusReporter, error) {
+	r := &PrometheusReporter{
+		peersAdded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kbucket",
+			Name:      "peers_added_total",
+			Help:      "Number of peers added to the routing table.",
+		}),
+		peersRemoved: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kbucket",
+			Name:      "peers_removed_total",
+			Help:      "Number of peers removed from the routing table.",
+		}),
+		peersRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kbucket",
+			Name:      "peers_rejected_total",
+			Help:      "Number of peers rejected from the routing table, by reason.",
+		}, []string{"reason"}),
+		bucketSplits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "kbucket",
+			Name:      "bucket_splits_total",
+			Help:      "Number of bucket splits performed.",
+		}),
+		lookupsServed: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "kbucket",
+			Name:      "lookup_served_seconds",
+			Help:      "Latency of NearestPeers lookups served by the routing table.",
+		}),
+		bucketOccupancy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "kbucket",
+			Name:      "bucket_occupancy",
+			Help:      "Number of peers occupying a bucket, by CPL.",
+		}, []string{"cpl"}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		r.peersAdded, r.peersRemoved, r.peersRejected,
+		r.bucketSplits, r.lookupsServed, r.bucketOccupancy,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// PeerAdded implements kbucket.MetricsReporter.
+func (r *PrometheusReporter) PeerAdded(peer.ID) {
+	r.peersAdded.Inc()
+}
+
+// PeerRemoved implements kbucket.MetricsReporter.
+func (r *PrometheusReporter) PeerRemoved(peer.ID) {
+	r.peersRemoved.Inc()
+}
+
+// PeerRejected implements kbucket.MetricsReporter.
+func (r *PrometheusReporter) PeerRejected(_ peer.ID, reason error) {
+	r.peersRejected.WithLabelValues(reason.Error()).Inc()
+}
+
+// BucketSplit implements kbucket.MetricsReporter.
+func (r *PrometheusReporter) BucketSplit(cpl int, sizeLeft, sizeRight int) {
+	r.bucketSplits.Inc()
+	r.bucketOccupancy.WithLabelValues(strconv.Itoa(cpl)).Set(float64(sizeLeft))
+	r.bucketOccupancy.WithLabelValues(strconv.Itoa(cpl + 1)).Set(float64(sizeRight))
+}
+
+// LookupServed implements kbucket.MetricsReporter.
+func (r *PrometheusReporter) LookupServed(target kbucket.ID, k int, returned int, latency time.Duration) {
+	r.lookupsServed.Observe(latency.Seconds())
+}
+
+// BucketOccupancy implements kbucket.MetricsReporter.
+func (r *PrometheusReporter) BucketOccupancy(cpl int, size int) {
+	r.bucketOccupancy.WithLabelValues(strconv.Itoa(cpl)).Set(float64(size))
+}
@@ -0,0 +1,27 @@
+package rtmetrics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusReporterRegistersAndRecords(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	r, err := NewPrometheusReporter(reg)
+	require.NoError(t, err)
+
+	r.PeerAdded("")
+	r.PeerRemoved("")
+	r.PeerRejected("", errors.New("insufficient capacity"))
+	r.BucketSplit(0, 1, 2)
+	r.BucketOccupancy(0, 3)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.NotEmpty(t, families)
+}
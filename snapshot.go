@@ -0,0 +1,159 @@
+package kbucket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// snapshotVersion is the version of the binary format written by Snapshot
+// and understood by LoadRoutingTable. It is bumped whenever the format
+// changes in a way that isn't backwards compatible.
+const snapshotVersion uint8 = 2
+
+// Snapshot writes a versioned, length-prefixed binary encoding of rt's
+// buckets to w, each recorded separately and in least-recently-used order
+// within itself. Each peer is recorded with its lastSuccessfulOutboundQuery
+// timestamp and whether it was replaceable at the time of the snapshot, so
+// LoadRoutingTable can warm-start a table without rediscovering the network
+// from bootstrap peers.
+//
+// Recording bucket boundaries explicitly, rather than one flat peer list,
+// lets LoadRoutingTable reproduce rt's exact bucket structure instead of
+// re-deriving it by replaying TryAddPeer: a flat list loses which peers
+// were rejected for capacity along the way, so replaying it in a different
+// order than the peers were originally added could strand a different set
+// of peers outside the table than the one Snapshot actually captured.
+func (rt *RoutingTable) Snapshot(w io.Writer) error {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
+
+	threshold := time.Duration(rt.maxLastSuccessfulOutboundThreshold)
+
+	if err := binary.Write(w, binary.BigEndian, snapshotVersion); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(rt.buckets))); err != nil {
+		return err
+	}
+
+	for _, b := range rt.buckets {
+		peers := b.peers()
+		if err := binary.Write(w, binary.BigEndian, uint32(len(peers))); err != nil {
+			return err
+		}
+		for _, pi := range peers {
+			if err := writeSnapshotPeer(w, pi, threshold); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeSnapshotPeer(w io.Writer, pi peerInfo, threshold time.Duration) error {
+	idBytes := []byte(pi.Id)
+	if err := binary.Write(w, binary.BigEndian, uint8(len(idBytes))); err != nil {
+		return err
+	}
+	if _, err := w.Write(idBytes); err != nil {
+		return err
+	}
+
+	var unixNano int64
+	if !pi.lastSuccessfulOutboundQuery.IsZero() {
+		unixNano = pi.lastSuccessfulOutboundQuery.UnixNano()
+	}
+	if err := binary.Write(w, binary.BigEndian, unixNano); err != nil {
+		return err
+	}
+
+	replaceable := !pi.lastSuccessfulOutboundQuery.IsZero() && time.Since(pi.lastSuccessfulOutboundQuery) > threshold
+	return binary.Write(w, binary.BigEndian, replaceable)
+}
+
+// LoadRoutingTable reconstructs a RoutingTable from a snapshot written by
+// Snapshot. Each bucket is rebuilt directly from its recorded peers, in
+// their original least-recently-used order, rather than by replaying
+// TryAddPeer: that reproduces rt's exact bucket structure and peer set as
+// they were at snapshot time, regardless of what order this function reads
+// them back in.
+func LoadRoutingTable(r io.Reader, local ID, bucketSize int, latency time.Duration, m pstore.Metrics, threshold float64) (*RoutingTable, error) {
+	var version uint8
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot version: %w", err)
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	var bucketCount uint32
+	if err := binary.Read(r, binary.BigEndian, &bucketCount); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot bucket count: %w", err)
+	}
+	if bucketCount == 0 {
+		return nil, fmt.Errorf("snapshot has no buckets")
+	}
+
+	rt, err := NewRoutingTable(bucketSize, local, latency, m, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]*bucket, bucketCount)
+	for i := range buckets {
+		b := newBucket()
+
+		var peerCount uint32
+		if err := binary.Read(r, binary.BigEndian, &peerCount); err != nil {
+			return nil, fmt.Errorf("failed to read bucket %d peer count: %w", i, err)
+		}
+
+		for j := uint32(0); j < peerCount; j++ {
+			p, lastSuccessfulOutboundQuery, _, err := readSnapshotPeer(r)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read peer %d of bucket %d: %w", j, i, err)
+			}
+			b.pushBack(&peerInfo{p, lastSuccessfulOutboundQuery, rt.keyFunc(p)})
+		}
+
+		buckets[i] = b
+	}
+	rt.buckets = buckets
+
+	return rt, nil
+}
+
+func readSnapshotPeer(r io.Reader) (peer.ID, time.Time, bool, error) {
+	var idLen uint8
+	if err := binary.Read(r, binary.BigEndian, &idLen); err != nil {
+		return "", time.Time{}, false, err
+	}
+
+	idBytes := make([]byte, idLen)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return "", time.Time{}, false, err
+	}
+
+	var unixNano int64
+	if err := binary.Read(r, binary.BigEndian, &unixNano); err != nil {
+		return "", time.Time{}, false, err
+	}
+
+	var replaceable bool
+	if err := binary.Read(r, binary.BigEndian, &replaceable); err != nil {
+		return "", time.Time{}, false, err
+	}
+
+	var t time.Time
+	if unixNano != 0 {
+		t = time.Unix(0, unixNano)
+	}
+
+	return peer.ID(idBytes), t, replaceable, nil
+}
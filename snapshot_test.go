@@ -0,0 +1,56 @@
+package kbucket
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	localID := ConvertPeerID(local)
+
+	rt, err := NewRoutingTable(5, localID, time.Hour, pstore.NewMetrics(), NoOpThreshold)
+	require.NoError(t, err)
+
+	// with bucketsize 5, a capacity rejection for a non-last bucket is
+	// expected as peers accumulate; only assert that some of them land.
+	for i := 0; i < 30; i++ {
+		p := test.RandPeerIDFatal(t)
+		_, _ = rt.TryAddPeer(p, i%2 == 0)
+	}
+	require.True(t, rt.Size() > 0)
+
+	var buf bytes.Buffer
+	require.NoError(t, rt.Snapshot(&buf))
+
+	restored, err := LoadRoutingTable(&buf, localID, 5, time.Hour, pstore.NewMetrics(), NoOpThreshold)
+	require.NoError(t, err)
+
+	require.ElementsMatch(t, rt.ListPeers(), restored.ListPeers())
+
+	// the restored table must reproduce rt's exact bucket structure, not
+	// just the same overall peer set: same bucket count, and each bucket's
+	// peers in the same least-recently-used order, since LoadRoutingTable
+	// rebuilds buckets directly from the snapshot instead of replaying
+	// TryAddPeer.
+	require.Equal(t, len(rt.buckets), len(restored.buckets))
+	for i := range rt.buckets {
+		orig := rt.buckets[i].peers()
+		got := restored.buckets[i].peers()
+		require.Equal(t, len(orig), len(got), "bucket %d peer count", i)
+		for j := range orig {
+			require.Equal(t, orig[j].Id, got[j].Id, "bucket %d peer %d", i, j)
+			require.Equal(t, orig[j].lastSuccessfulOutboundQuery.Unix(), got[j].lastSuccessfulOutboundQuery.Unix())
+		}
+	}
+
+	target := ConvertKey("nearest test")
+	require.Equal(t, rt.NearestPeers(target, 10), restored.NearestPeers(target, 10))
+}
@@ -0,0 +1,72 @@
+package kbucket
+
+import (
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// peerDistance is a peer paired with its distance to a target ID, used for
+// sorting peers by distance.
+type peerDistance struct {
+	p        peer.ID
+	distance ID
+}
+
+type peerDistanceSorter struct {
+	peers  []peerDistance
+	target ID
+}
+
+func (pds *peerDistanceSorter) Len() int { return len(pds.peers) }
+func (pds *peerDistanceSorter) Swap(a, b int) {
+	pds.peers[a], pds.peers[b] = pds.peers[b], pds.peers[a]
+}
+func (pds *peerDistanceSorter) Less(a, b int) bool {
+	return lessDistance(pds.peers[a].distance, pds.peers[b].distance)
+}
+
+func (pds *peerDistanceSorter) appendPeer(p peer.ID, dhtId ID) {
+	pds.peers = append(pds.peers, peerDistance{
+		p:        p,
+		distance: xor(pds.target, dhtId),
+	})
+}
+
+// lessDistance reports whether a is numerically smaller than b, treating
+// both as big-endian unsigned integers of equal length.
+func lessDistance(a, b ID) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// SortClosestPeers returns a copy of peers, sorted by ascending XOR distance
+// from target.
+func SortClosestPeers(peers []peer.ID, target ID) []peer.ID {
+	return sortClosestPeersByKey(peers, target, ConvertPeerID)
+}
+
+// sortClosestPeersByKey is SortClosestPeers, but deriving each peer's
+// Kademlia key with kf instead of assuming ConvertPeerID. Used by
+// RoutingTable so NearestPeers respects a table configured with
+// WithKeyFunc.
+func sortClosestPeersByKey(peers []peer.ID, target ID, kf KeyFunc) []peer.ID {
+	sorter := &peerDistanceSorter{
+		peers:  make([]peerDistance, 0, len(peers)),
+		target: target,
+	}
+	for _, p := range peers {
+		sorter.appendPeer(p, kf(p))
+	}
+	sort.Sort(sorter)
+
+	out := make([]peer.ID, 0, len(sorter.peers))
+	for _, pd := range sorter.peers {
+		out = append(out, pd.p)
+	}
+	return out
+}
@@ -0,0 +1,483 @@
+package kbucket
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+var log = logging.Logger("table")
+
+// ErrPeerRejectedHighLatency is returned when a peer is rejected because
+// its recorded latency is above the table's configured maximum.
+var ErrPeerRejectedHighLatency = errors.New("peer rejected; latency too high")
+
+// ErrPeerRejectedNoCapacity is returned when a peer is rejected because its
+// target bucket (and, where applicable, the table as a whole) has no free
+// capacity for it.
+var ErrPeerRejectedNoCapacity = errors.New("peer rejected; insufficient capacity")
+
+// ErrPeerRejectedLowDiversity is returned when a peer is rejected by the
+// table's configured PeerDiversityFilter.
+var ErrPeerRejectedLowDiversity = errors.New("peer rejected; low peer diversity")
+
+// RoutingTable defines the routing table. It is not threadsafe by default,
+// but all of its public methods take care of the necessary locking.
+type RoutingTable struct {
+	// ID of the local peer, converted into the Kademlia keyspace.
+	local ID
+
+	// Blanket lock, ignore the more specific ones for now.
+	tabLock sync.RWMutex
+
+	// latency metrics
+	metrics pstore.Metrics
+
+	// Maximum acceptable latency for peers in this cluster.
+	maxLatency time.Duration
+
+	// buckets, ordered by increasing common-prefix-length with the local
+	// peer, i.e. buckets[0] is the bucket for peers with CPL 0.
+	buckets    []*bucket
+	bucketsize int
+
+	// maxLastSuccessfulOutboundThreshold is the duration, expressed as a
+	// float64 of nanoseconds, after which a peer we have not successfully
+	// queried becomes eligible for replacement by an incoming peer.
+	maxLastSuccessfulOutboundThreshold float64
+
+	// PeerRemoved, if set, is called whenever a peer is removed from the
+	// table.
+	PeerRemoved func(peer.ID)
+
+	// PeerAdded, if set, is called whenever a peer is added to the table.
+	PeerAdded func(peer.ID)
+
+	// df, if set, is consulted by TryAddPeer before a new peer is admitted,
+	// so it can reject peers that would make the table too dominated by a
+	// single network group. Configured via WithPeerDiversityFilter.
+	df PeerDiversityFilter
+
+	// reporter, if set, receives structured events describing table
+	// activity. Configured via WithMetricsReporter.
+	reporter MetricsReporter
+
+	// keyFunc derives the Kademlia key used to place a peer.ID in the
+	// table. Defaults to ConvertPeerID; override with WithKeyFunc.
+	keyFunc KeyFunc
+}
+
+// NewRoutingTable creates a new routing table with the given bucket size,
+// local ID, latency tolerance, and peer metrics store.
+//
+// maxLastSuccessfulOutboundThreshold is the maximum duration (expressed in
+// nanoseconds as a float64) since a peer's last successful outbound query
+// before that peer becomes replaceable by an incoming peer when its bucket
+// is full.
+func NewRoutingTable(bucketsize int, localID ID, latency time.Duration, m pstore.Metrics, maxLastSuccessfulOutboundThreshold float64) (*RoutingTable, error) {
+	rt := &RoutingTable{
+		buckets:                            []*bucket{newBucket()},
+		bucketsize:                         bucketsize,
+		local:                              localID,
+		maxLatency:                         latency,
+		metrics:                            m,
+		maxLastSuccessfulOutboundThreshold: maxLastSuccessfulOutboundThreshold,
+		PeerRemoved:                        nil,
+		PeerAdded:                          nil,
+		keyFunc:                            ConvertPeerID,
+	}
+
+	return rt, nil
+}
+
+// NewRoutingTableWithOptions is like NewRoutingTable, but applies the given
+// Options to the table before returning it (for example
+// WithPeerDiversityFilter).
+func NewRoutingTableWithOptions(bucketsize int, localID ID, latency time.Duration, m pstore.Metrics, maxLastSuccessfulOutboundThreshold float64, opts ...Option) (*RoutingTable, error) {
+	rt, err := NewRoutingTable(bucketsize, localID, latency, m, maxLastSuccessfulOutboundThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, opt := range opts {
+		if err := opt(rt); err != nil {
+			return nil, err
+		}
+	}
+
+	return rt, nil
+}
+
+// Print prints a descriptive statement about the provided RoutingTable.
+func (rt *RoutingTable) Print() {
+	fmt.Printf("Routing Table, bs = %d, Max latency = %d\n", rt.bucketsize, rt.maxLatency)
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
+
+	for i, b := range rt.buckets {
+		fmt.Printf("\tbucket: %d\n", i)
+
+		for e := b.list.Front(); e != nil; e = e.Next() {
+			p := e.Value.(*peerInfo).Id
+			fmt.Printf("\t\t- %s\n", p.Pretty())
+		}
+	}
+}
+
+// maxGenRandPeerIDAttempts bounds the rejection-sampling loop in
+// GenRandPeerID, so a pathologically high targetCpl fails with an error
+// instead of looping forever.
+const maxGenRandPeerIDAttempts = 1 << 20
+
+// GenRandPeerID generates a peer.ID whose key, once passed through
+// rt.keyFunc, falls within the given common prefix length (CPL) of the
+// local peer. The returned peer.ID is not a valid, dialable peer
+// identity: it exists only to serve as a lookup target that shares the
+// requested CPL with the local key, e.g. for RefreshManager to probe a
+// specific bucket.
+//
+// rt.keyFunc is a one-way hash (SHA-256 by default), so the prefix can't
+// be crafted directly by flipping bits of rt.local as one might with an
+// unhashed keyspace: candidates are drawn at random and hashed until one
+// lands at the requested CPL.
+func (rt *RoutingTable) GenRandPeerID(targetCpl uint) (peer.ID, error) {
+	if targetCpl >= uint(len(rt.local)*8) {
+		return "", fmt.Errorf("cannot generate peer ID for cpl %d or greater", len(rt.local)*8)
+	}
+
+	candidate := make([]byte, 32)
+	for i := 0; i < maxGenRandPeerIDAttempts; i++ {
+		if _, err := rand.Read(candidate); err != nil {
+			return "", err
+		}
+
+		p := peer.ID(candidate)
+		if uint(CommonPrefixLen(rt.keyFunc(p), rt.local)) == targetCpl {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to generate a peer ID for cpl %d after %d attempts", targetCpl, maxGenRandPeerIDAttempts)
+}
+
+// bucketIdForPeer returns the bucket index that p belongs in, given the
+// current number of buckets.
+func (rt *RoutingTable) bucketIdForPeer(p peer.ID) int {
+	peerID := rt.keyFunc(p)
+	cpl := CommonPrefixLen(peerID, rt.local)
+	bucketID := cpl
+	if bucketID >= len(rt.buckets) {
+		bucketID = len(rt.buckets) - 1
+	}
+	return bucketID
+}
+
+// TryAddPeer tries to add a peer to the routing table. If the peer was
+// queried (i.e. queryPeer is true), its lastSuccessfulOutboundQuery is set
+// to the current time. Returns true if the peer was added (or already
+// present and updated), and an error explaining why the peer was rejected
+// otherwise.
+func (rt *RoutingTable) TryAddPeer(p peer.ID, queryPeer bool) (bool, error) {
+	rt.tabLock.Lock()
+	defer rt.tabLock.Unlock()
+
+	bucketID := rt.bucketIdForPeer(p)
+	bucket := rt.buckets[bucketID]
+
+	if peerInf := bucket.getPeer(p); peerInf != nil {
+		if queryPeer {
+			peerInf.lastSuccessfulOutboundQuery = time.Now()
+		}
+		bucket.touchLastRefreshed()
+		return true, nil
+	}
+
+	if rt.metrics != nil {
+		latency := rt.metrics.LatencyEWMA(p)
+		if latency > rt.maxLatency && rt.maxLatency != 0 {
+			rt.reportRejected(p, ErrPeerRejectedHighLatency)
+			return false, ErrPeerRejectedHighLatency
+		}
+	}
+
+	if bucket.len() >= rt.bucketsize {
+		if bucketID == len(rt.buckets)-1 {
+			// last bucket: split and retry.
+			rt.nextBucket()
+			return rt.addPeerLocked(p, queryPeer)
+		}
+
+		if replace, ok := rt.replaceableInBucket(bucket); ok {
+			bucket.remove(replace)
+			rt.removed(replace)
+		} else {
+			rt.reportRejected(p, ErrPeerRejectedNoCapacity)
+			return false, ErrPeerRejectedNoCapacity
+		}
+	}
+
+	// only consult the diversity filter once a slot for p is certain, so a
+	// peer that diversity-passes but then loses to a capacity rejection
+	// doesn't leak a phantom count in the filter.
+	if rt.df != nil && !rt.df.Allow(p, bucketID) {
+		rt.reportRejected(p, ErrPeerRejectedLowDiversity)
+		return false, ErrPeerRejectedLowDiversity
+	}
+
+	var t time.Time
+	if queryPeer {
+		t = time.Now()
+	}
+
+	bucket.pushFront(&peerInfo{p, t, rt.keyFunc(p)})
+	bucket.touchLastRefreshed()
+	rt.reportAdded(p, bucketID, bucket.len())
+	if rt.PeerAdded != nil {
+		rt.PeerAdded(p)
+	}
+	return true, nil
+}
+
+// addPeerLocked is TryAddPeer's logic assuming the table lock is already
+// held, used after a bucket split to retry the insert.
+func (rt *RoutingTable) addPeerLocked(p peer.ID, queryPeer bool) (bool, error) {
+	bucketID := rt.bucketIdForPeer(p)
+	bucket := rt.buckets[bucketID]
+
+	if peerInf := bucket.getPeer(p); peerInf != nil {
+		if queryPeer {
+			peerInf.lastSuccessfulOutboundQuery = time.Now()
+		}
+		bucket.touchLastRefreshed()
+		return true, nil
+	}
+
+	if bucket.len() >= rt.bucketsize {
+		if replace, ok := rt.replaceableInBucket(bucket); ok {
+			bucket.remove(replace)
+			rt.removed(replace)
+		} else {
+			rt.reportRejected(p, ErrPeerRejectedNoCapacity)
+			return false, ErrPeerRejectedNoCapacity
+		}
+	}
+
+	if rt.df != nil && !rt.df.Allow(p, bucketID) {
+		rt.reportRejected(p, ErrPeerRejectedLowDiversity)
+		return false, ErrPeerRejectedLowDiversity
+	}
+
+	var t time.Time
+	if queryPeer {
+		t = time.Now()
+	}
+
+	bucket.pushFront(&peerInfo{p, t, rt.keyFunc(p)})
+	bucket.touchLastRefreshed()
+	rt.reportAdded(p, bucketID, bucket.len())
+	if rt.PeerAdded != nil {
+		rt.PeerAdded(p)
+	}
+	return true, nil
+}
+
+// reportAdded notifies rt.reporter, if set, that p was added to the
+// bucket for cpl, which now holds size peers.
+func (rt *RoutingTable) reportAdded(p peer.ID, cpl, size int) {
+	if rt.reporter == nil {
+		return
+	}
+	rt.reporter.PeerAdded(p)
+	rt.reporter.BucketOccupancy(cpl, size)
+}
+
+// reportRejected notifies rt.reporter, if set, that p was refused
+// admission for reason.
+func (rt *RoutingTable) reportRejected(p peer.ID, reason error) {
+	if rt.reporter != nil {
+		rt.reporter.PeerRejected(p, reason)
+	}
+}
+
+// replaceableInBucket looks for a peer in the bucket whose
+// lastSuccessfulOutboundQuery is older than the configured threshold, and
+// is therefore eligible to be evicted to make room for a new peer.
+func (rt *RoutingTable) replaceableInBucket(b *bucket) (peer.ID, bool) {
+	threshold := time.Duration(rt.maxLastSuccessfulOutboundThreshold)
+	for _, pi := range b.peers() {
+		if pi.lastSuccessfulOutboundQuery.IsZero() {
+			continue
+		}
+		if time.Since(pi.lastSuccessfulOutboundQuery) > threshold {
+			return pi.Id, true
+		}
+	}
+	return "", false
+}
+
+// nextBucket splits the last bucket in the table into two buckets, the
+// first keeping the peers whose CPL with local equals the split CPL, and
+// the second (appended) receiving those that share at least one more bit
+// with local.
+func (rt *RoutingTable) nextBucket() {
+	splitCpl := len(rt.buckets) - 1
+	bucket := rt.buckets[splitCpl]
+	newBucket := bucket.split(splitCpl, rt.local)
+	rt.buckets = append(rt.buckets, newBucket)
+	newBucketID := splitCpl + 1
+
+	// every peer that moved into newBucket was, until now, accounted for
+	// by rt.df under splitCpl: re-key it under its real bucket so the
+	// diversity filter's per-bucket counts don't go stale across a split.
+	if rt.df != nil {
+		for _, pi := range newBucket.peers() {
+			rt.df.Move(pi.Id, splitCpl, newBucketID)
+		}
+	}
+
+	if rt.reporter != nil {
+		rt.reporter.BucketSplit(splitCpl, bucket.len(), newBucket.len())
+	}
+}
+
+func (rt *RoutingTable) removed(p peer.ID) {
+	if rt.df != nil {
+		rt.df.Remove(p, rt.bucketIdForPeer(p))
+	}
+	if rt.reporter != nil {
+		rt.reporter.PeerRemoved(p)
+	}
+	if rt.PeerRemoved != nil {
+		rt.PeerRemoved(p)
+	}
+}
+
+// RemovePeer removes a peer from the routing table.
+func (rt *RoutingTable) RemovePeer(p peer.ID) {
+	rt.tabLock.Lock()
+	defer rt.tabLock.Unlock()
+
+	bucketID := rt.bucketIdForPeer(p)
+	bucket := rt.buckets[bucketID]
+	if bucket.remove(p) {
+		rt.removed(p)
+		if rt.reporter != nil {
+			rt.reporter.BucketOccupancy(bucketID, bucket.len())
+		}
+	}
+}
+
+// UpdateLastSuccessfulOutboundQuery updates the peer's
+// lastSuccessfulOutboundQuery timestamp. Returns false if the peer is not
+// in the table.
+func (rt *RoutingTable) UpdateLastSuccessfulOutboundQuery(p peer.ID, t time.Time) bool {
+	rt.tabLock.Lock()
+	defer rt.tabLock.Unlock()
+
+	bucketID := rt.bucketIdForPeer(p)
+	bucket := rt.buckets[bucketID]
+	pi := bucket.getPeer(p)
+	if pi == nil {
+		return false
+	}
+	pi.lastSuccessfulOutboundQuery = t
+	bucket.touchLastRefreshed()
+	return true
+}
+
+// Find looks for a specific peer by ID and returns it if found, or the zero
+// peer.ID otherwise.
+func (rt *RoutingTable) Find(id peer.ID) peer.ID {
+	srch := rt.NearestPeers(rt.keyFunc(id), 1)
+	if len(srch) == 0 || srch[0] != id {
+		return ""
+	}
+	return srch[0]
+}
+
+// NearestPeer returns the single peer closest to the given ID.
+func (rt *RoutingTable) NearestPeer(id ID) peer.ID {
+	peers := rt.NearestPeers(id, 1)
+	if len(peers) > 0 {
+		return peers[0]
+	}
+	return ""
+}
+
+// NearestPeers returns the count closest peers to the given ID.
+func (rt *RoutingTable) NearestPeers(id ID, count int) []peer.ID {
+	start := time.Now()
+	rt.tabLock.RLock()
+
+	cpl := CommonPrefixLen(id, rt.local)
+
+	bucketID := cpl
+	if bucketID >= len(rt.buckets) {
+		bucketID = len(rt.buckets) - 1
+	}
+
+	var peerArr []peer.ID
+	for _, pi := range rt.buckets[bucketID].peers() {
+		peerArr = append(peerArr, pi.Id)
+	}
+
+	// if the bucket isn't full, collect peers from buckets that are close
+	// in both directions.
+	for i := 1; len(peerArr) < count && (bucketID-i >= 0 || bucketID+i < len(rt.buckets)); i++ {
+		if bucketID-i >= 0 {
+			for _, pi := range rt.buckets[bucketID-i].peers() {
+				peerArr = append(peerArr, pi.Id)
+			}
+		}
+		if bucketID+i < len(rt.buckets) {
+			for _, pi := range rt.buckets[bucketID+i].peers() {
+				peerArr = append(peerArr, pi.Id)
+			}
+		}
+	}
+
+	rt.tabLock.RUnlock()
+
+	sorted := sortClosestPeersByKey(peerArr, id, rt.keyFunc)
+	if len(sorted) > count {
+		sorted = sorted[:count]
+	}
+
+	if rt.reporter != nil {
+		rt.reporter.LookupServed(id, count, len(sorted), time.Since(start))
+	}
+
+	return sorted
+}
+
+// ListPeers returns a list of all peers currently in the table.
+func (rt *RoutingTable) ListPeers() []peer.ID {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
+
+	var peers []peer.ID
+	for _, b := range rt.buckets {
+		for _, pi := range b.peers() {
+			peers = append(peers, pi.Id)
+		}
+	}
+	return peers
+}
+
+// Size returns the total number of peers in the routing table.
+func (rt *RoutingTable) Size() int {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
+
+	var tot int
+	for _, b := range rt.buckets {
+		tot += b.len()
+	}
+	return tot
+}
@@ -0,0 +1,107 @@
+package kbucket
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ErrLookupFailure is returned if a routing table query returns no results.
+var ErrLookupFailure = errors.New("failed to find any peer in table")
+
+// KadKey is a Kademlia key used to order and group peers (or other
+// content) in the XOR keyspace. Keys are not assumed to share a single
+// fixed length: Xor treats the shorter operand as zero-padded on the
+// right, and CommonPrefixLength compares only the bytes both keys have.
+type KadKey []byte
+
+// ID is a Kademlia ID used to order and group peers in the routing table.
+// It is derived from a peer.ID (or arbitrary key) by hashing it. ID is an
+// alias of KadKey: the routing table's default hashing strategy produces
+// fixed 256-bit keys, but nothing in the table itself assumes that length.
+type ID = KadKey
+
+// ConvertPeerID creates a Kademlia ID from a peer.ID by hashing it. This is
+// used to convert between peer.ID and the internal key space used for
+// distance calculations within the table.
+func ConvertPeerID(id peer.ID) ID {
+	hash := sha256.Sum256([]byte(id))
+	return hash[:]
+}
+
+// ConvertKey creates a Kademlia ID from a string key by hashing it.
+func ConvertKey(id string) ID {
+	hash := sha256.Sum256([]byte(id))
+	return hash[:]
+}
+
+// KeyFunc derives a KadKey from a peer.ID. NewRoutingTable uses
+// ConvertPeerID by default; WithKeyFunc lets callers plug in a different
+// hash, or one producing shorter keys, for testing or alternative keyspace
+// choices.
+type KeyFunc func(peer.ID) KadKey
+
+// Xor returns the XOR of k and other. If they differ in length, the
+// shorter key is treated as zero-padded on the right: the result has the
+// length of the longer key, and copies the longer key's trailing bytes
+// past the shorter key's end unchanged.
+func (k KadKey) Xor(other KadKey) KadKey {
+	n := len(k)
+	if len(other) > n {
+		n = len(other)
+	}
+
+	out := make(KadKey, n)
+	for i := 0; i < n; i++ {
+		var a, b byte
+		if i < len(k) {
+			a = k[i]
+		}
+		if i < len(other) {
+			b = other[i]
+		}
+		out[i] = a ^ b
+	}
+	return out
+}
+
+// CommonPrefixLength returns the number of leading bits k shares with
+// other. Keys of unequal length are compared over their shared
+// min(len(k), len(other)) bytes; if those are all equal, the common
+// prefix length is 8 * min(len(k), len(other)).
+func (k KadKey) CommonPrefixLength(other KadKey) int {
+	n := len(k)
+	if len(other) < n {
+		n = len(other)
+	}
+
+	for i := 0; i < n; i++ {
+		if k[i] != other[i] {
+			return i*8 + leadingZeros8(k[i]^other[i])
+		}
+	}
+	return n * 8
+}
+
+func leadingZeros8(b byte) int {
+	n := 0
+	for i := 7; i >= 0; i-- {
+		if b&(1<<uint(i)) != 0 {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// xor returns the XOR of two Kademlia keys.
+func xor(a, b ID) ID {
+	return a.Xor(b)
+}
+
+// CommonPrefixLen returns the number of leading bits shared by the two
+// Kademlia IDs, i.e. the length of the common prefix in the XOR keyspace.
+func CommonPrefixLen(a, b ID) int {
+	return a.CommonPrefixLength(b)
+}
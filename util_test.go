@@ -0,0 +1,47 @@
+package kbucket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommonPrefixLenEqualLength(t *testing.T) {
+	t.Parallel()
+
+	a := ID{0xff, 0x00}
+	b := ID{0xff, 0x0f}
+	require.Equal(t, 12, CommonPrefixLen(a, b))
+	require.Equal(t, 16, CommonPrefixLen(a, a))
+}
+
+func TestCommonPrefixLenUnequalLength(t *testing.T) {
+	t.Parallel()
+
+	// shared bytes are identical, so the shorter key exhausts itself at
+	// its own length.
+	short := ID{0xff}
+	long := ID{0xff, 0x00, 0x00}
+	require.Equal(t, 8, CommonPrefixLen(short, long))
+	require.Equal(t, 8, CommonPrefixLen(long, short))
+
+	// a divergent bit inside the shared prefix is found regardless of
+	// which operand is longer.
+	short2 := ID{0xf0}
+	long2 := ID{0xff, 0xff}
+	require.Equal(t, 4, CommonPrefixLen(short2, long2))
+	require.Equal(t, 4, CommonPrefixLen(long2, short2))
+}
+
+func TestXorUnequalLength(t *testing.T) {
+	t.Parallel()
+
+	short := ID{0xff}
+	long := ID{0xff, 0xaa, 0x55}
+
+	got := short.Xor(long)
+	require.Equal(t, ID{0x00, 0xaa, 0x55}, got)
+
+	// XOR is commutative even across mismatched lengths.
+	require.Equal(t, got, long.Xor(short))
+}